@@ -6,8 +6,9 @@ import (
 )
 
 type BaseDataWriter struct {
-	mutex sync.Mutex // Mutex to protect shared queue.
-	file  *os.File   // append only file to write data.
+	mutex   sync.Mutex // Mutex to protect shared queue.
+	file    *os.File   // append only file to write data.
+	metrics writerMetrics
 }
 
 func NewBaseDataWriter(file *os.File, queueSize int) *BaseDataWriter {
@@ -20,4 +21,11 @@ func (w *BaseDataWriter) Push(data []byte) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 	w.file.Write(data)
+	w.metrics.recordWrite(1, len(data))
+}
+
+// Metrics returns a snapshot of this writer's observability counters. It is
+// safe to call concurrently with Push.
+func (w *BaseDataWriter) Metrics() DataWriterMetrics {
+	return w.metrics.snapshot(0)
 }
@@ -0,0 +1,48 @@
+package datawriter
+
+// Batcher decides when a run of queued requests accumulated by
+// backgroundWriter is ready to flush.
+type Batcher interface {
+	// Add appends req to the batch and reports whether it should be flushed
+	// now.
+	Add(req *writeRequest) (flush bool)
+	// Reset clears the batch after a flush.
+	Reset()
+	// Requests returns the requests accumulated since the last Reset.
+	Requests() []*writeRequest
+}
+
+// CountBatcher flushes once MaxItems requests have accumulated.
+type CountBatcher struct {
+	MaxItems int
+	items    []*writeRequest
+}
+
+func (b *CountBatcher) Add(req *writeRequest) bool {
+	b.items = append(b.items, req)
+	return len(b.items) >= b.MaxItems
+}
+
+func (b *CountBatcher) Reset()                    { b.items = b.items[:0] }
+func (b *CountBatcher) Requests() []*writeRequest { return b.items }
+
+// SizeBatcher flushes once the accumulated request payloads total at least
+// MaxBytes.
+type SizeBatcher struct {
+	MaxBytes int
+	items    []*writeRequest
+	bytes    int
+}
+
+func (b *SizeBatcher) Add(req *writeRequest) bool {
+	b.items = append(b.items, req)
+	b.bytes += len(req.data)
+	return b.bytes >= b.MaxBytes
+}
+
+func (b *SizeBatcher) Reset() {
+	b.items = b.items[:0]
+	b.bytes = 0
+}
+
+func (b *SizeBatcher) Requests() []*writeRequest { return b.items }
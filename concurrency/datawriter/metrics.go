@@ -0,0 +1,104 @@
+package datawriter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DataWriterMetrics is a point-in-time snapshot of a writer's observability
+// counters, modeled after Pebble's LogWriter.Metrics().
+type DataWriterMetrics struct {
+	BytesWritten        uint64
+	RecordsWritten      uint64
+	BatchesFlushed      uint64
+	SyncCount           uint64
+	SyncDuration        time.Duration
+	PushBlockedDuration time.Duration
+	QueueFullEvents     uint64
+	CurrentQueueDepth   uint64
+	MaxQueueDepth       uint64
+}
+
+// Merge adds other's counters into m so callers running multiple writers can
+// roll up totals. Cumulative counters are summed; MaxQueueDepth takes the
+// larger of the two, since it is itself already a running maximum.
+func (m *DataWriterMetrics) Merge(other *DataWriterMetrics) {
+	m.BytesWritten += other.BytesWritten
+	m.RecordsWritten += other.RecordsWritten
+	m.BatchesFlushed += other.BatchesFlushed
+	m.SyncCount += other.SyncCount
+	m.SyncDuration += other.SyncDuration
+	m.PushBlockedDuration += other.PushBlockedDuration
+	m.QueueFullEvents += other.QueueFullEvents
+	m.CurrentQueueDepth += other.CurrentQueueDepth
+	if other.MaxQueueDepth > m.MaxQueueDepth {
+		m.MaxQueueDepth = other.MaxQueueDepth
+	}
+}
+
+// writerMetrics holds the live, atomically-updated counters backing a
+// writer's Metrics() method. Every field is an atomic so Metrics() can be
+// called concurrently with active Push/writeBatch calls; once a writer is
+// closed no further updates occur and the snapshot is stable.
+type writerMetrics struct {
+	bytesWritten      atomic.Uint64
+	recordsWritten    atomic.Uint64
+	batchesFlushed    atomic.Uint64
+	syncCount         atomic.Uint64
+	syncDurationNanos atomic.Int64
+	pushBlockedNanos  atomic.Int64
+	queueFullEvents   atomic.Uint64
+	maxQueueDepth     atomic.Uint64
+}
+
+// recordWrite accounts for records/bytes applied to the file, independent of
+// whether or when they are fsynced. BaseDataWriter, which has no group-commit
+// batching of its own, calls this directly rather than recordBatch so its
+// counters don't falsely imply a sync happened on every Push.
+func (m *writerMetrics) recordWrite(records, bytes int) {
+	m.recordsWritten.Add(uint64(records))
+	m.bytesWritten.Add(uint64(bytes))
+}
+
+func (m *writerMetrics) recordBatch(records, bytes int, syncDuration time.Duration) {
+	m.recordWrite(records, bytes)
+	m.batchesFlushed.Add(1)
+	m.syncCount.Add(1)
+	m.syncDurationNanos.Add(int64(syncDuration))
+}
+
+func (m *writerMetrics) recordQueueFull() {
+	m.queueFullEvents.Add(1)
+}
+
+func (m *writerMetrics) recordPushBlocked(d time.Duration) {
+	m.pushBlockedNanos.Add(int64(d))
+}
+
+// observeQueueDepth updates the running maximum queue depth given the depth
+// just after an item was enqueued.
+func (m *writerMetrics) observeQueueDepth(depth int) {
+	for {
+		cur := m.maxQueueDepth.Load()
+		if uint64(depth) <= cur {
+			return
+		}
+		if m.maxQueueDepth.CompareAndSwap(cur, uint64(depth)) {
+			return
+		}
+	}
+}
+
+func (m *writerMetrics) snapshot(currentQueueDepth int) DataWriterMetrics {
+	return DataWriterMetrics{
+		BytesWritten:        m.bytesWritten.Load(),
+		RecordsWritten:      m.recordsWritten.Load(),
+		BatchesFlushed:      m.batchesFlushed.Load(),
+		SyncCount:           m.syncCount.Load(),
+		SyncDuration:        time.Duration(m.syncDurationNanos.Load()),
+		PushBlockedDuration: time.Duration(m.pushBlockedNanos.Load()),
+		QueueFullEvents:     m.queueFullEvents.Load(),
+		CurrentQueueDepth:   uint64(currentQueueDepth),
+		MaxQueueDepth:       m.maxQueueDepth.Load(),
+	}
+}
@@ -1,8 +1,8 @@
 package datawriter
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"os"
 	"sync"
 	"time"
@@ -10,129 +10,398 @@ import (
 
 // multiple producer and single consumer pattern to write data to a file
 
+// OverflowPolicy controls what Push does once the queue has reached its
+// item or byte budget. The zero value is ReturnError.
+type OverflowPolicy struct {
+	kind    overflowKind
+	timeout time.Duration
+}
+
+type overflowKind int
+
+const (
+	overflowReturnError overflowKind = iota
+	overflowBlockForever
+	overflowBlockWithTimeout
+	overflowDropNewest
+	overflowDropOldest
+)
+
+var (
+	// ReturnError fails Push immediately with ErrQueueFull once the queue
+	// is at capacity. This is the default policy.
+	ReturnError = OverflowPolicy{kind: overflowReturnError}
+	// BlockForever parks Push until the background writer frees up space.
+	BlockForever = OverflowPolicy{kind: overflowBlockForever}
+	// DropNewest discards the item being pushed and returns nil.
+	DropNewest = OverflowPolicy{kind: overflowDropNewest}
+	// DropOldest evicts the oldest queued item to make room for the new one.
+	DropOldest = OverflowPolicy{kind: overflowDropOldest}
+)
+
+// BlockWithTimeout parks Push until space is freed or d elapses, returning
+// ErrQueueFull on timeout.
+func BlockWithTimeout(d time.Duration) OverflowPolicy {
+	return OverflowPolicy{kind: overflowBlockWithTimeout, timeout: d}
+}
+
+// writeRequest is a queued item paired with the synchronization needed to
+// hand a durability signal back to its producer. writeBatch releases done
+// and syncDone together, once the request's batch has been written to the
+// file and that batch's fsync has completed. Requests are pooled since producers push
+// far more often than they allocate.
+type writeRequest struct {
+	data     []byte
+	done     sync.WaitGroup
+	syncDone sync.WaitGroup
+	err      error
+}
+
+var writeRequestPool = sync.Pool{
+	New: func() any { return new(writeRequest) },
+}
+
+// WriteHandle is returned by Push/PushContext and lets a producer learn when
+// its write has been applied (Wait) or made durable (SyncWait).
+type WriteHandle struct {
+	req *writeRequest
+}
+
+// Wait blocks until the handle's data has been written to the file and
+// returns any error observed doing so. writeBatch releases Wait only after
+// the batch's fsync, so Wait returns at the same point as SyncWait and also
+// reports a sync error; unlike SyncWait it does not recycle the request.
+func (h *WriteHandle) Wait() error {
+	h.req.done.Wait()
+	return h.req.err
+}
+
+// SyncWait blocks until the group fsync covering this handle's write has
+// completed and returns any write or sync error observed. It recycles the
+// underlying request, so each handle's SyncWait must be called at most once.
+func (h *WriteHandle) SyncWait() error {
+	h.req.syncDone.Wait()
+	err := h.req.err
+	writeRequestPool.Put(h.req)
+	return err
+}
+
 type MPSCWriter struct {
-	queue chan []byte // queue channel multiple producers and single consumer.
-	file  *os.File    // append only file to write data.
+	queue chan *writeRequest // queue channel multiple producers and single consumer.
+	file  *os.File           // append only file to write data.
 	wg    sync.WaitGroup
-	// shutdown chan bool // Add this
+
+	mu       sync.Mutex
+	notFull  *sync.Cond
+	closed   bool // set by Close; producers parked on notFull must recheck this before sending
+	byteLen  int  // bytes currently queued, tracked alongside len(queue)
+	maxBytes int  // 0 means unbounded
+	overflow OverflowPolicy
+
+	batcher    Batcher
+	maxLatency time.Duration
+
+	metrics writerMetrics
 }
 
+// defaultBatchSize and defaultMaxLatency preserve the batch size and flush
+// interval the writer has always used.
+const (
+	defaultBatchSize  = 5
+	defaultMaxLatency = 10 * time.Millisecond
+)
+
 func NewMPSCWriter(file *os.File, queuesize int) *MPSCWriter {
+	return NewMPSCWriterWithPolicy(file, queuesize, 0, ReturnError)
+}
+
+// NewMPSCWriterWithPolicy is like NewMPSCWriter but additionally bounds the
+// queue by maxBytes (0 means unbounded) and applies overflow once either the
+// item count (queuesize) or the byte budget is exhausted.
+func NewMPSCWriterWithPolicy(file *os.File, queuesize int, maxBytes int, overflow OverflowPolicy) *MPSCWriter {
+	return NewMPSCWriterWithBatcher(file, queuesize, maxBytes, overflow,
+		&CountBatcher{MaxItems: defaultBatchSize}, defaultMaxLatency)
+}
+
+// NewMPSCWriterWithBatcher is like NewMPSCWriterWithPolicy but lets the
+// caller choose how batches are formed (batcher) and the maximum time a
+// request may sit in a forming batch before it is flushed regardless
+// (maxLatency).
+func NewMPSCWriterWithBatcher(file *os.File, queuesize int, maxBytes int, overflow OverflowPolicy, batcher Batcher, maxLatency time.Duration) *MPSCWriter {
 	writer := &MPSCWriter{
-		queue: make(chan []byte, queuesize),
-		file:  file,
-		// shutdown: make(chan bool), // Initialize the shutdown channel
+		queue:      make(chan *writeRequest, queuesize),
+		file:       file,
+		maxBytes:   maxBytes,
+		overflow:   overflow,
+		batcher:    batcher,
+		maxLatency: maxLatency,
 	}
-	go writer.backgroundWriter()
+	writer.notFull = sync.NewCond(&writer.mu)
 	writer.wg.Add(1)
+	go writer.backgroundWriter()
 	return writer
 }
 
+// Close signals that no more data is coming and waits for the background
+// writer to flush everything already queued. Producers parked in
+// PushContext (BlockForever, BlockWithTimeout, or waiting on DropOldest to
+// free space) are woken and return ErrWriterClosed instead of racing Close
+// to send on the channel it is about to close: PushContext holds w.mu from
+// its last check of w.closed through the final send, so acquiring w.mu here
+// before closing the channel guarantees no producer is mid-send when it
+// closes.
 func (w *MPSCWriter) Close() error {
-	// if w.shutdown != nil {
-	// 	close(w.shutdown) // Signal shutdown
-	// 	w.wg.Wait()       // Wait for drain to complete
-	// }
+	w.mu.Lock()
+	w.closed = true
+	w.notFull.Broadcast()
+	w.mu.Unlock()
+
 	close(w.queue) // Signal no more data coming
 	w.wg.Wait()    // Wait for background writer to finish
 	return nil
 }
 
+// backgroundWriter accumulates requests into w.batcher and flushes either
+// when the batcher says it's full or when maxLatency elapses since the
+// first request of the current batch, whichever comes first. The flush
+// timer is reset only when a batch actually starts forming, not on every
+// item, so it bounds the age of the oldest queued request rather than
+// sliding on every arrival.
 func (w *MPSCWriter) backgroundWriter() {
-	// ms ticker
-	ticker := time.NewTicker(10 * time.Millisecond)
-	defer ticker.Stop()
-
-	batch := make([][]byte, 0, cap(w.queue)) // slice of byte slices
 	defer w.wg.Done()
+
+	flushTimer := time.NewTimer(w.maxLatency)
+	if !flushTimer.Stop() {
+		<-flushTimer.C
+	}
+	timerArmed := false
+
+	flush := func() {
+		if timerArmed {
+			if !flushTimer.Stop() {
+				<-flushTimer.C
+			}
+			timerArmed = false
+		}
+		if len(w.batcher.Requests()) == 0 {
+			return
+		}
+		batch := append([]*writeRequest(nil), w.batcher.Requests()...)
+		w.batcher.Reset()
+		w.writeBatch(batch)
+	}
+
 	for {
 		select {
-		case data, ok := <-w.queue:
+		case req, ok := <-w.queue:
 			if !ok {
-				// Channel closed - drain and exit
-				if len(batch) > 0 {
-					w.writeBatch(batch)
-				}
+				flush()
 				return
 			}
-			batch = append(batch, data)
-		// collect data from the queue channel
-		case <-ticker.C:
-			fmt.Print("Collecting data from queue... ")
-			for len(batch) < 5 { // write in batches of 100
-				fmt.Println("writing to batch...", len(batch), batch)
-				select {
-				case data := <-w.queue:
-					batch = append(batch, data)
-				default:
-					fmt.Println("No more data in queue, writing batch if any...")
-					goto writeBatch
-				}
-			}
-		writeBatch:
-			if len(batch) > 0 {
-				w.writeBatch(batch)
-				batch = batch[:0] // reset batch
+			startingBatch := len(w.batcher.Requests()) == 0
+			if w.batcher.Add(req) {
+				flush()
+			} else if startingBatch {
+				flushTimer.Reset(w.maxLatency)
+				timerArmed = true
 			}
+		case <-flushTimer.C:
+			timerArmed = false
+			flush()
 		}
 	}
 }
 
-func (w *MPSCWriter) writeBatch(batch [][]byte) {
-	fmt.Printf("Writing batch of %d items to file.\n", len(batch))
-	for _, data := range batch {
-		w.file.Write(data)
+// writeBatch issues a single file.Write per queued request followed by one
+// group fsync, then fans the outcome back out to every request in the batch
+// so each producer gets an accurate durability signal and error instead of
+// the two being dropped on the floor.
+func (w *MPSCWriter) writeBatch(batch []*writeRequest) {
+	freed := 0
+	var writeErr error
+	for _, req := range batch {
+		if _, err := w.file.Write(req.data); err != nil && writeErr == nil {
+			writeErr = err
+		}
+		freed += len(req.data)
 	}
-	w.file.Sync() // ensure data is flushed to disk
-	fmt.Printf("Wrote batch of %d items to file.\n", len(batch))
+	syncStart := time.Now()
+	syncErr := w.file.Sync() // ensure data is flushed to disk
+	w.metrics.recordBatch(len(batch), freed, time.Since(syncStart))
+
+	for _, req := range batch {
+		req.err = writeErr
+		if req.err == nil {
+			req.err = syncErr
+		}
+		req.done.Done()
+		req.syncDone.Done()
+	}
+
+	// Items have left the queue: release their share of the byte budget and
+	// wake any producers parked in Push/PushContext, since a producer whose
+	// item is smaller than the one that just freed up space may now fit
+	// even though the producer that triggered the wakeup wouldn't.
+	w.mu.Lock()
+	w.byteLen -= freed
+	w.notFull.Broadcast()
+	w.mu.Unlock()
 }
 
 var ErrQueueFull = errors.New("Queue is full, backpressure applied")
 
-func (w *MPSCWriter) Push(data []byte) error {
+// ErrWriterClosed is returned by PushContext (and therefore Push/PushSync)
+// once Close has been called, whether the call was already in flight or
+// parked waiting for backpressure to clear.
+var ErrWriterClosed = errors.New("datawriter: writer is closed")
 
-	// where's the queue size limit handling?
-	select {
-	case w.queue <- data: // send data to the queue channel, natuarally blocks if the channel is full
-		// successfully sent
-		fmt.Println("Data pushed to queue successfully.")
-		return nil
-	default:
-		// handle queue full backpressure
-		// queue is full, block until there's space
-		fmt.Println("Queue full, blocking until space is available...")
-		return ErrQueueFull
-	}
+// Push enqueues data, applying the writer's OverflowPolicy once the queue is
+// at capacity, and returns a handle for observing the write's durability.
+// It is equivalent to PushContext(context.Background(), data).
+func (w *MPSCWriter) Push(data []byte) (*WriteHandle, error) {
+	return w.PushContext(context.Background(), data)
+}
 
+// PushAsync is Push under a name that mirrors PushSync: it returns as soon
+// as data is enqueued, handing back a handle whose SyncWait blocks until the
+// group fsync covering it completes.
+func (w *MPSCWriter) PushAsync(data []byte) (*WriteHandle, error) {
+	return w.Push(data)
 }
 
-func (w *MPSCWriter) drainQueue() {
-	fmt.Println("Draining remaining items from queue...")
+// PushSync enqueues data and blocks the caller until it has been durably
+// written, returning any write or sync error observed for it. Under
+// DropNewest, Push returns a nil handle for a dropped item; PushSync treats
+// that as a no-op rather than dereferencing it.
+func (w *MPSCWriter) PushSync(data []byte) error {
+	handle, err := w.Push(data)
+	if err != nil || handle == nil {
+		return err
+	}
+	return handle.SyncWait()
+}
 
-	// Process all remaining items in the queue
-	batch := make([][]byte, 0)
+// PushContext is like Push but honors ctx cancellation while parked under
+// BlockForever or BlockWithTimeout.
+func (w *MPSCWriter) PushContext(ctx context.Context, data []byte) (*WriteHandle, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-drainLoop:
-	for {
-		select {
-		case data := <-w.queue:
-			batch = append(batch, data)
+	// Wake parked producers once ctx is done, so a cancellation is observed
+	// without waiting for the next flush to broadcast on notFull.
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() {
+			w.mu.Lock()
+			w.notFull.Broadcast()
+			w.mu.Unlock()
+		})
+		defer stop()
+	}
 
-			// Write in batches to avoid huge memory usage
-			if len(batch) >= 100 {
-				w.writeBatch(batch)
-				batch = batch[:0] // Reset batch
+	// deadline is only meaningful for overflowBlockWithTimeout. It is
+	// computed once so that repeated spurious wakeups (writeBatch
+	// broadcasts on every flush, not just the one that frees enough space
+	// for this particular item) don't keep pushing the timeout out.
+	var deadline time.Time
+	if w.overflow.kind == overflowBlockWithTimeout {
+		deadline = time.Now().Add(w.overflow.timeout)
+	}
+
+	blockStart := time.Now()
+	blocked := false
+	for !w.closed && w.fullLocked(len(data)) {
+		w.metrics.recordQueueFull()
+		switch w.overflow.kind {
+		case overflowReturnError:
+			return nil, ErrQueueFull
+		case overflowDropNewest:
+			return nil, nil
+		case overflowDropOldest:
+			if !w.evictOldestLocked() {
+				// Nothing sitting in the channel to evict right now (the
+				// congesting bytes are likely in the batcher, already
+				// dequeued but not yet flushed): wait for a flush to free
+				// them up instead of busy-spinning while holding w.mu,
+				// which would otherwise starve backgroundWriter forever.
+				blocked = true
+				w.notFull.Wait()
+			}
+		case overflowBlockWithTimeout:
+			blocked = true
+			remaining := time.Until(deadline)
+			if remaining <= 0 || !w.waitLocked(remaining) {
+				return nil, ErrQueueFull
 			}
-		default:
-			// No more items in queue
-			break drainLoop
+		default: // overflowBlockForever
+			blocked = true
+			w.notFull.Wait()
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
 	}
+	if blocked {
+		w.metrics.recordPushBlocked(time.Since(blockStart))
+	}
+	if w.closed {
+		return nil, ErrWriterClosed
+	}
 
-	// Write final batch if any items remain
-	if len(batch) > 0 {
-		w.writeBatch(batch)
+	w.byteLen += len(data)
+	req := writeRequestPool.Get().(*writeRequest)
+	req.data = data
+	req.err = nil
+	req.done.Add(1)
+	req.syncDone.Add(1)
+	w.queue <- req
+	w.metrics.observeQueueDepth(len(w.queue))
+	return &WriteHandle{req: req}, nil
+}
+
+// Metrics returns a snapshot of this writer's observability counters. It is
+// safe to call concurrently with active Push/PushContext calls, and the
+// snapshot is stable once Close has returned.
+func (w *MPSCWriter) Metrics() DataWriterMetrics {
+	return w.metrics.snapshot(len(w.queue))
+}
+
+// fullLocked reports whether pushing n more bytes would exceed the item or
+// byte budget. w.mu must be held.
+func (w *MPSCWriter) fullLocked(n int) bool {
+	if len(w.queue) >= cap(w.queue) {
+		return true
 	}
+	return w.maxBytes > 0 && w.byteLen+n > w.maxBytes
+}
+
+// evictOldestLocked drops the oldest queued item, if any, to make room for a
+// new one, reporting whether it evicted something. w.mu must be held.
+func (w *MPSCWriter) evictOldestLocked() bool {
+	select {
+	case old := <-w.queue:
+		w.byteLen -= len(old.data)
+		old.err = ErrQueueFull
+		old.done.Done()
+		old.syncDone.Done()
+		return true
+	default:
+		return false
+	}
+}
 
-	fmt.Printf("Finished draining queue. Processed all remaining items.\n")
+// waitLocked parks on notFull until woken or d elapses, reporting whether it
+// was woken before the timeout. w.mu must be held; it is released while
+// waiting and re-acquired before returning, per sync.Cond.Wait semantics.
+func (w *MPSCWriter) waitLocked(d time.Duration) bool {
+	timedOut := false
+	timer := time.AfterFunc(d, func() {
+		w.mu.Lock()
+		timedOut = true
+		w.notFull.Broadcast()
+		w.mu.Unlock()
+	})
+	defer timer.Stop()
+	w.notFull.Wait()
+	return !timedOut
 }
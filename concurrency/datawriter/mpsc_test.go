@@ -0,0 +1,265 @@
+package datawriter
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMPSCWriterNoItemLostOnClose pushes a known set of items from several
+// concurrent producers and verifies every one of them reaches the file
+// before Close returns, regardless of batcher/timer timing.
+func TestMPSCWriterNoItemLostOnClose(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "mpsc-*.txt")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer file.Close()
+
+	const producers = 8
+	const itemsPerProducer = 50
+
+	w := NewMPSCWriter(file, 16)
+
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	for p := 0; p < producers; p++ {
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < itemsPerProducer; i++ {
+				handle, err := w.Push([]byte(fmt.Sprintf("p%d-i%d\n", p, i)))
+				if err != nil {
+					t.Errorf("Push: %v", err)
+					return
+				}
+				if err := handle.Wait(); err != nil {
+					t.Errorf("Wait: %v", err)
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	contents, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	want := producers * itemsPerProducer
+	got := 0
+	for _, b := range contents {
+		if b == '\n' {
+			got++
+		}
+	}
+	if got != want {
+		t.Fatalf("got %d records written, want %d", got, want)
+	}
+}
+
+// TestMPSCWriterDropOldestByteBudgetDoesNotDeadlock reproduces a case where
+// the congesting bytes have already been dequeued into the (unflushed)
+// batcher, so there is nothing sitting in the channel for DropOldest to
+// evict. Push must wait for the next flush to free the budget instead of
+// busy-spinning forever while holding w.mu.
+func TestMPSCWriterDropOldestByteBudgetDoesNotDeadlock(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "mpsc-*.txt")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer file.Close()
+
+	w := NewMPSCWriterWithBatcher(file, 4, 5, DropOldest, &CountBatcher{MaxItems: 1000}, 50*time.Millisecond)
+
+	if _, err := w.Push([]byte("abcde")); err != nil {
+		t.Fatalf("first Push: %v", err)
+	}
+
+	// Give the background writer a chance to dequeue the first item into
+	// its batch before the 50ms flush timer fires, so the channel is empty
+	// but the byte budget is still fully consumed.
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := w.Push([]byte("fghij")); err != nil {
+			t.Errorf("second Push: %v", err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Push under DropOldest with a byte budget deadlocked")
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+// TestMPSCWriterBlockWithTimeoutBounded verifies that BlockWithTimeout keeps
+// to its configured deadline even while other producers keep flushing (and
+// therefore broadcasting on notFull) in the meantime.
+func TestMPSCWriterBlockWithTimeoutBounded(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "mpsc-*.txt")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer file.Close()
+
+	w := NewMPSCWriterWithBatcher(file, 10, 1, BlockWithTimeout(30*time.Millisecond), &CountBatcher{MaxItems: 1}, 2*time.Millisecond)
+
+	stop := make(chan struct{})
+	var producer sync.WaitGroup
+	producer.Add(1)
+	go func() {
+		defer producer.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				w.Push([]byte("x")) // fits the budget once flushed; keeps generating broadcasts
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := w.Push(make([]byte, 10)); err != ErrQueueFull { // never fits the 1-byte budget
+			t.Errorf("oversized Push: got err %v, want ErrQueueFull", err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("BlockWithTimeout push never returned despite repeated spurious wakeups")
+	}
+	elapsed := time.Since(start)
+
+	close(stop)
+	producer.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if elapsed > 300*time.Millisecond {
+		t.Fatalf("BlockWithTimeout push took %v, want close to the 30ms deadline", elapsed)
+	}
+}
+
+// TestMPSCWriterCloseWakesBlockedPushWithoutPanic reproduces a producer
+// parked in PushContext (BlockForever) on a full byte budget while Close
+// runs concurrently. Close's own final flush frees the budget and broadcasts
+// notFull, which used to race the unsynchronized close(w.queue) in Close and
+// panic with "send on closed channel"; the parked Push must instead observe
+// ErrWriterClosed.
+func TestMPSCWriterCloseWakesBlockedPushWithoutPanic(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "mpsc-*.txt")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer file.Close()
+
+	w := NewMPSCWriterWithBatcher(file, 4, 5, BlockForever, &CountBatcher{MaxItems: 1000}, 50*time.Millisecond)
+
+	if _, err := w.Push([]byte("abcde")); err != nil {
+		t.Fatalf("first Push: %v", err)
+	}
+
+	// Give the background writer a chance to dequeue the first item into its
+	// batch before the 50ms flush timer fires, so the channel is empty but
+	// the byte budget is still fully consumed and the second push parks.
+	time.Sleep(10 * time.Millisecond)
+
+	pushDone := make(chan error, 1)
+	go func() {
+		_, err := w.Push([]byte("fghij"))
+		pushDone <- err
+	}()
+
+	// Give the second push a moment to actually park on notFull before
+	// racing it against Close.
+	time.Sleep(10 * time.Millisecond)
+
+	closeDone := make(chan error, 1)
+	go func() {
+		closeDone <- w.Close()
+	}()
+
+	select {
+	case err := <-pushDone:
+		if err != ErrWriterClosed {
+			t.Fatalf("blocked Push during Close: got err %v, want ErrWriterClosed", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Push blocked on a closing writer never returned")
+	}
+
+	select {
+	case err := <-closeDone:
+		if err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Close never returned")
+	}
+}
+
+// TestMPSCWriterPushSyncDropNewestNoPanic verifies PushSync doesn't
+// dereference the nil handle Push returns when DropNewest silently drops an
+// item.
+func TestMPSCWriterPushSyncDropNewestNoPanic(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "mpsc-*.txt")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer file.Close()
+
+	// A byte budget that the first push fully consumes, and a batcher that
+	// never flushes on its own, so the budget stays congested and the
+	// second push is guaranteed to be dropped.
+	w := NewMPSCWriterWithBatcher(file, 4, 4, DropNewest, &CountBatcher{MaxItems: 1000}, time.Hour)
+
+	if _, err := w.Push([]byte("keep")); err != nil {
+		t.Fatalf("first Push: %v", err)
+	}
+
+	if err := w.PushSync([]byte("dropped")); err != nil {
+		t.Fatalf("PushSync on a dropped item: got err %v, want nil", err)
+	}
+}
+
+// TestCountBatcherFlushesAtMaxItems verifies the batcher interface itself,
+// independent of the background writer's timing.
+func TestCountBatcherFlushesAtMaxItems(t *testing.T) {
+	b := &CountBatcher{MaxItems: 3}
+
+	reqs := []*writeRequest{{data: []byte("a")}, {data: []byte("b")}, {data: []byte("c")}}
+	for i, req := range reqs {
+		flush := b.Add(req)
+		wantFlush := i == len(reqs)-1
+		if flush != wantFlush {
+			t.Fatalf("Add(%d): flush=%v, want %v", i, flush, wantFlush)
+		}
+	}
+	if got := len(b.Requests()); got != 3 {
+		t.Fatalf("Requests() len = %d, want 3", got)
+	}
+	b.Reset()
+	if got := len(b.Requests()); got != 0 {
+		t.Fatalf("Requests() after Reset len = %d, want 0", got)
+	}
+}
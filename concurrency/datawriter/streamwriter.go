@@ -0,0 +1,187 @@
+package datawriter
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// StreamWriter accepts writes from N independent producers, each of which
+// guarantees its own keys are pushed in ascending order, and merges them
+// into a single sorted output file. Unlike MPSCWriter's serialized Push,
+// each stream has its own buffered channel and producers never contend with
+// one another on the hot write path; a single background goroutine performs
+// a k-way merge across the per-stream heads.
+type StreamWriter struct {
+	file    *os.File
+	streams []*streamHandle
+	done    chan struct{}
+	err     error
+}
+
+// streamHandle guards a single stream's channel with its own mutex and
+// closed flag, so a Write racing Close on that stream returns
+// ErrStreamClosed instead of panicking on a send to a closed channel.
+// Streams are independent: one stream's mutex never blocks another's Write.
+type streamHandle struct {
+	mu     sync.Mutex
+	ch     chan streamItem
+	closed bool
+}
+
+type streamItem struct {
+	streamID int
+	key      []byte
+	value    []byte
+}
+
+// streamHeap is a min-heap over streamItem ordered by key, breaking ties by
+// streamID so merge output is deterministic when two streams share a key.
+type streamHeap []streamItem
+
+func (h streamHeap) Len() int { return len(h) }
+func (h streamHeap) Less(i, j int) bool {
+	if c := compareBytes(h[i].key, h[j].key); c != 0 {
+		return c < 0
+	}
+	return h[i].streamID < h[j].streamID
+}
+func (h streamHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *streamHeap) Push(x any)   { *h = append(*h, x.(streamItem)) }
+func (h *streamHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+func compareBytes(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+const streamBufferSize = 64
+
+// NewStreamWriter creates a StreamWriter accepting writes on numStreams
+// independent streams, merging them into file as they arrive.
+func NewStreamWriter(file *os.File, numStreams int) *StreamWriter {
+	w := &StreamWriter{
+		file:    file,
+		streams: make([]*streamHandle, numStreams),
+		done:    make(chan struct{}),
+	}
+	for i := range w.streams {
+		w.streams[i] = &streamHandle{ch: make(chan streamItem, streamBufferSize)}
+	}
+	go w.merge()
+	return w
+}
+
+// ErrStreamClosed is returned by Write once Close has been called, whether
+// the call raced Close on that exact stream or happened afterward.
+var ErrStreamClosed = errors.New("datawriter: stream writer is closed")
+
+// Write enqueues a key/value pair on the given stream. Keys written to the
+// same streamID must be in ascending order. Write takes only that stream's
+// own mutex, so it never contends with callers writing to a different
+// streamID; it returns ErrStreamClosed rather than panicking if it races
+// Close.
+func (w *StreamWriter) Write(streamID int, key, value []byte) error {
+	if streamID < 0 || streamID >= len(w.streams) {
+		return fmt.Errorf("datawriter: streamID %d out of range [0,%d)", streamID, len(w.streams))
+	}
+	s := w.streams[streamID]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	s.ch <- streamItem{streamID: streamID, key: key, value: value}
+	return nil
+}
+
+// Flush forces any data already written to the output file to disk. It does
+// not wait for items still queued on a stream; call Close for that.
+func (w *StreamWriter) Flush() error {
+	return w.file.Sync()
+}
+
+// Close signals that no more streams will receive writes, waits for the
+// merge to drain every remaining heap entry in sorted order, and returns the
+// first error observed while writing the merged output. It is safe to call
+// concurrently with in-flight Writes: each stream's own mutex ensures a
+// Write racing Close either completes first or observes ErrStreamClosed,
+// never a send on an already-closed channel.
+func (w *StreamWriter) Close() error {
+	for _, s := range w.streams {
+		s.mu.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mu.Unlock()
+	}
+	<-w.done
+	return w.err
+}
+
+// merge performs a k-way merge across the per-stream channels using a
+// min-heap keyed by (key, streamID). When a stream's channel is closed and
+// its last buffered item drained, the stream is simply not re-added to the
+// heap.
+func (w *StreamWriter) merge() {
+	defer close(w.done)
+
+	h := &streamHeap{}
+	heap.Init(h)
+	for i, s := range w.streams {
+		if item, ok := <-s.ch; ok {
+			item.streamID = i
+			heap.Push(h, item)
+		}
+	}
+
+	for h.Len() > 0 {
+		item := heap.Pop(h).(streamItem)
+		if err := w.writeRecord(item); err != nil && w.err == nil {
+			w.err = err
+		}
+		if next, ok := <-w.streams[item.streamID].ch; ok {
+			next.streamID = item.streamID
+			heap.Push(h, next)
+		}
+	}
+}
+
+// writeRecord appends a length-prefixed key/value record to the output
+// file, so the merged stream can be read back without ambiguity about where
+// one record ends and the next begins.
+func (w *StreamWriter) writeRecord(item streamItem) error {
+	var lengths [8]byte
+	binary.BigEndian.PutUint32(lengths[0:4], uint32(len(item.key)))
+	binary.BigEndian.PutUint32(lengths[4:8], uint32(len(item.value)))
+	if _, err := w.file.Write(lengths[:]); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(item.key); err != nil {
+		return err
+	}
+	_, err := w.file.Write(item.value)
+	return err
+}
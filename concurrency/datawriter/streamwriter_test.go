@@ -0,0 +1,147 @@
+package datawriter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// readStreamRecords parses the length-prefixed key/value records written by
+// StreamWriter back out of contents.
+func readStreamRecords(t *testing.T, contents []byte) [][2]string {
+	t.Helper()
+
+	var records [][2]string
+	for off := 0; off < len(contents); {
+		if off+8 > len(contents) {
+			t.Fatalf("truncated record header at offset %d", off)
+		}
+		keyLen := binary.BigEndian.Uint32(contents[off : off+4])
+		valLen := binary.BigEndian.Uint32(contents[off+4 : off+8])
+		off += 8
+
+		if off+int(keyLen)+int(valLen) > len(contents) {
+			t.Fatalf("truncated record body at offset %d", off)
+		}
+		key := string(contents[off : off+int(keyLen)])
+		off += int(keyLen)
+		value := string(contents[off : off+int(valLen)])
+		off += int(valLen)
+
+		records = append(records, [2]string{key, value})
+	}
+	return records
+}
+
+// TestStreamWriterMergesSortedOutput feeds several streams, each internally
+// ascending but interleaved out of order with one another, and verifies
+// Close drains every item and the merged output is fully sorted by key.
+func TestStreamWriterMergesSortedOutput(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "streamwriter-*.bin")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer file.Close()
+
+	w := NewStreamWriter(file, 3)
+
+	streams := [][]int{
+		{0, 3, 6, 9},
+		{1, 4, 7},
+		{2, 5, 8, 10},
+	}
+	want := 0
+	for streamID, keys := range streams {
+		for _, k := range keys {
+			if err := w.Write(streamID, []byte(fmt.Sprintf("%03d", k)), []byte(fmt.Sprintf("v%d", k))); err != nil {
+				t.Fatalf("Write(stream %d, key %d): %v", streamID, k, err)
+			}
+			want++
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	contents, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	records := readStreamRecords(t, contents)
+
+	if got := len(records); got != want {
+		t.Fatalf("got %d merged records, want %d", got, want)
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i-1][0] >= records[i][0] {
+			t.Fatalf("merged output not strictly sorted at index %d: %q >= %q", i, records[i-1][0], records[i][0])
+		}
+	}
+}
+
+// TestStreamWriterRejectsOutOfRangeStreamID verifies Write validates its
+// streamID argument rather than indexing the streams slice out of bounds.
+func TestStreamWriterRejectsOutOfRangeStreamID(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "streamwriter-*.bin")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer file.Close()
+
+	w := NewStreamWriter(file, 2)
+	defer w.Close()
+
+	if err := w.Write(2, []byte("k"), []byte("v")); err == nil {
+		t.Fatal("Write with out-of-range streamID: got nil error, want an error")
+	}
+}
+
+// TestStreamWriterWriteAfterCloseReturnsError reproduces a producer still
+// calling Write while Close races it on the same stream: Write must return
+// ErrStreamClosed instead of panicking with "send on closed channel".
+func TestStreamWriterWriteAfterCloseReturnsError(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "streamwriter-*.bin")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer file.Close()
+
+	w := NewStreamWriter(file, 1)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if err := w.Write(0, []byte("k"), []byte("v")); err != ErrStreamClosed {
+		t.Fatalf("Write after Close: got err %v, want ErrStreamClosed", err)
+	}
+}
+
+// TestStreamWriterWriteRacingCloseDoesNotPanic drives Write and Close
+// concurrently on the same stream so the race detector and any unsynchronized
+// close(ch) would catch a "send on closed channel" panic.
+func TestStreamWriterWriteRacingCloseDoesNotPanic(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "streamwriter-*.bin")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer file.Close()
+
+	w := NewStreamWriter(file, 1)
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for i := 0; i < 1000; i++ {
+			if err := w.Write(0, []byte(fmt.Sprintf("%04d", i)), []byte("v")); err != nil {
+				return // ErrStreamClosed once Close wins the race; expected.
+			}
+		}
+	}()
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	<-writerDone
+}
@@ -0,0 +1,202 @@
+// Package optimistic provides a generic compare-and-swap-with-retry
+// primitive for version-column-guarded updates, plus a SELECT ... FOR UPDATE
+// counterpart for callers that want to hold a row lock instead of retrying.
+package optimistic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Mutation describes a single versioned UPDATE: the row to update, located
+// by PKColumn/PKValue, guarded by VersionColumn at the value the caller
+// observed (Version), and the columns to set.
+type Mutation struct {
+	Table         string
+	PKColumn      string
+	PKValue       any
+	VersionColumn string
+	Version       int
+	Sets          map[string]any
+}
+
+// OptimisticFn reads whatever state it needs from tx, including the row's
+// current version, and describes the mutation Do should attempt.
+type OptimisticFn func(tx *sql.Tx) (Mutation, error)
+
+// Backoff computes the delay before retry attempt n (1-indexed).
+type Backoff func(attempt int) time.Duration
+
+// ConstantBackoff waits d before every retry.
+func ConstantBackoff(d time.Duration) Backoff {
+	return func(attempt int) time.Duration { return d }
+}
+
+// ExponentialBackoff waits base*2^(attempt-1), capped at max.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		d := base << (attempt - 1)
+		if d <= 0 || d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// ExponentialBackoffWithJitter is ExponentialBackoff with up to full jitter
+// applied on top, to spread out retries when many callers conflict at once.
+func ExponentialBackoffWithJitter(base, max time.Duration) Backoff {
+	exp := ExponentialBackoff(base, max)
+	return func(attempt int) time.Duration {
+		d := exp(attempt)
+		return time.Duration(rand.Int63n(int64(d) + 1))
+	}
+}
+
+// DefaultMaxAttempts is used when Options.MaxAttempts is unset.
+const DefaultMaxAttempts = 5
+
+// Options configures Do's retry behavior and observability hooks.
+type Options struct {
+	MaxAttempts int     // 0 means DefaultMaxAttempts
+	Backoff     Backoff // nil means retry immediately
+
+	// OnAttempt, if set, is called after every attempt with its outcome:
+	// conflict is true when the versioned UPDATE affected zero rows, and err
+	// carries any non-conflict failure (a nil err with conflict false means
+	// the attempt committed successfully).
+	OnAttempt func(attempt int, conflict bool, err error)
+}
+
+// ErrConflictExhausted is returned by Do when MaxAttempts versioned updates
+// in a row all lost the compare-and-swap race.
+type ErrConflictExhausted struct {
+	Table       string
+	PKValue     any
+	LastVersion int
+	Attempts    int
+}
+
+func (e *ErrConflictExhausted) Error() string {
+	return fmt.Sprintf("optimistic: %s id=%v exhausted %d attempts, last observed version=%d",
+		e.Table, e.PKValue, e.Attempts, e.LastVersion)
+}
+
+// Do reads whatever state fn needs (including the row's current version),
+// issues an UPDATE guarded by that version, and retries according to opts if
+// another writer won the race in the meantime. Each attempt runs in its own
+// transaction so fn's reads and the guarded UPDATE are consistent with one
+// another. Do returns *ErrConflictExhausted once opts.MaxAttempts attempts
+// have all conflicted.
+func Do(ctx context.Context, db *sql.DB, opts Options, fn OptimisticFn) error {
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	var lastMutation Mutation
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+
+		mutation, conflict, err := attemptOnce(ctx, tx, fn)
+		if err != nil {
+			tx.Rollback()
+			if opts.OnAttempt != nil {
+				opts.OnAttempt(attempt, false, err)
+			}
+			return err
+		}
+		lastMutation = mutation
+
+		if !conflict {
+			err = tx.Commit()
+			if opts.OnAttempt != nil {
+				opts.OnAttempt(attempt, false, err)
+			}
+			return err
+		}
+
+		tx.Rollback()
+		if opts.OnAttempt != nil {
+			opts.OnAttempt(attempt, true, nil)
+		}
+		// Back off before the next attempt, unless this was the last one: the
+		// loop ending naturally below is what reports ErrConflictExhausted.
+		if attempt < maxAttempts && opts.Backoff != nil {
+			select {
+			case <-time.After(opts.Backoff(attempt)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return &ErrConflictExhausted{
+		Table:       lastMutation.Table,
+		PKValue:     lastMutation.PKValue,
+		LastVersion: lastMutation.Version,
+		Attempts:    maxAttempts,
+	}
+}
+
+func attemptOnce(ctx context.Context, tx *sql.Tx, fn OptimisticFn) (Mutation, bool, error) {
+	mutation, err := fn(tx)
+	if err != nil {
+		return mutation, false, err
+	}
+
+	query, args := buildVersionedUpdate(mutation)
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return mutation, false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return mutation, false, err
+	}
+	return mutation, affected == 0, nil
+}
+
+func buildVersionedUpdate(m Mutation) (string, []any) {
+	sets := make([]string, 0, len(m.Sets)+1)
+	args := make([]any, 0, len(m.Sets)+2)
+	for col, val := range m.Sets {
+		sets = append(sets, col+" = ?")
+		args = append(args, val)
+	}
+	sets = append(sets, fmt.Sprintf("%s = %s + 1", m.VersionColumn, m.VersionColumn))
+
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = ? AND %s = ?",
+		m.Table, strings.Join(sets, ", "), m.PKColumn, m.VersionColumn)
+	args = append(args, m.PKValue, m.Version)
+	return query, args
+}
+
+// WithRowLock opens a transaction, executes sqlSelectForUpdate (expected to
+// be a SELECT ... FOR UPDATE) to acquire the row lock, invokes fn on the
+// locked transaction, and commits on success or rolls back on error.
+func WithRowLock(ctx context.Context, db *sql.DB, sqlSelectForUpdate string, args []any, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, sqlSelectForUpdate, args...)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	rows.Close()
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
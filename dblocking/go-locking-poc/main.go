@@ -9,6 +9,8 @@ import (
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+
+	"system-design/db/optimistic"
 )
 
 const (
@@ -44,41 +46,42 @@ func now() string {
 
 func pessimisticDemo(db *sql.DB) {
 	log.Printf("==== Pessimistic Locking Demo (SELECT ... FOR UPDATE) ====")
-
-	// tx1 acquires row lock, sleeps, then updates
-	tx1, err := db.Begin()
-	must(err)
-
-	// Using context to show blocking behavior on tx2
 	ctx := context.Background()
+	const lockSQL = "SELECT qty FROM items WHERE id = ? FOR UPDATE"
 
-	log.Printf("[%s] tx1: SELECT ... FOR UPDATE", now())
-	var qty1 int
-	err = tx1.QueryRowContext(ctx, "SELECT qty FROM items WHERE id = 1 FOR UPDATE").Scan(&qty1)
-	must(err)
-	log.Printf("[%s] tx1: got qty=%d, holding lock for 2s...", now(), qty1)
-
-	// Start tx2 concurrently
+	// tx1 acquires the row lock, sleeps, then updates.
+	tx1Started := make(chan struct{})
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
-		tx2, err := db.Begin()
-		must(err)
-		var qty2 int
+		<-tx1Started
 		log.Printf("[%s] tx2: trying SELECT ... FOR UPDATE (will block until tx1 commits)", now())
-		err = tx2.QueryRowContext(ctx, "SELECT qty FROM items WHERE id = 1 FOR UPDATE").Scan(&qty2)
-		must(err)
-		log.Printf("[%s] tx2: acquired lock, read qty=%d", now(), qty2)
-		_, err = tx2.ExecContext(ctx, "UPDATE items SET qty = qty - 5 WHERE id = 1")
+		err := optimistic.WithRowLock(ctx, db, lockSQL, []any{1}, func(tx *sql.Tx) error {
+			var qty2 int
+			if err := tx.QueryRowContext(ctx, "SELECT qty FROM items WHERE id = 1").Scan(&qty2); err != nil {
+				return err
+			}
+			log.Printf("[%s] tx2: acquired lock, read qty=%d", now(), qty2)
+			_, err := tx.ExecContext(ctx, "UPDATE items SET qty = qty - 5 WHERE id = 1")
+			return err
+		})
 		must(err)
-		must(tx2.Commit())
 		log.Printf("[%s] tx2: committed", now())
 	}()
 
-	time.Sleep(2 * time.Second) // simulate work while holding the lock
-	_, err = tx1.ExecContext(ctx, "UPDATE items SET qty = qty - 10 WHERE id = 1")
+	log.Printf("[%s] tx1: SELECT ... FOR UPDATE", now())
+	err := optimistic.WithRowLock(ctx, db, lockSQL, []any{1}, func(tx *sql.Tx) error {
+		var qty1 int
+		if err := tx.QueryRowContext(ctx, "SELECT qty FROM items WHERE id = 1").Scan(&qty1); err != nil {
+			return err
+		}
+		log.Printf("[%s] tx1: got qty=%d, holding lock for 2s...", now(), qty1)
+		close(tx1Started)
+		time.Sleep(2 * time.Second) // simulate work while holding the lock
+		_, err := tx.ExecContext(ctx, "UPDATE items SET qty = qty - 10 WHERE id = 1")
+		return err
+	})
 	must(err)
-	must(tx1.Commit())
 	log.Printf("[%s] tx1: committed", now())
 
 	<-done
@@ -87,32 +90,27 @@ func pessimisticDemo(db *sql.DB) {
 	log.Printf("[%s] final qty after pessimistic demo: %d (expect 100 -10 -5 = 85)", now(), qty)
 }
 
-func optimisticAttempt(db *sql.DB, id int, delta int, attempt int) (bool, error) {
-	// Read current version & qty
-	var version, qty int
-	err := db.QueryRow("SELECT version, qty FROM items WHERE id = ?", id).Scan(&version, &qty)
-	if err != nil {
-		return false, err
-	}
-
-	// Simulate some work while "holding" the stale version
-	time.Sleep(time.Duration(200+rand.Intn(200)) * time.Millisecond)
+// optimisticUpdateQty builds the OptimisticFn that reads the row's current
+// qty/version and describes the versioned UPDATE to apply delta.
+func optimisticUpdateQty(id int, delta int) optimistic.OptimisticFn {
+	return func(tx *sql.Tx) (optimistic.Mutation, error) {
+		var version, qty int
+		if err := tx.QueryRow("SELECT version, qty FROM items WHERE id = ?", id).Scan(&version, &qty); err != nil {
+			return optimistic.Mutation{}, err
+		}
 
-	// Try compare-and-swap style update
-	res, err := db.Exec("UPDATE items SET qty = ?, version = version + 1 WHERE id = ? AND version = ?", qty+delta, id, version)
-	if err != nil {
-		return false, err
+		// Simulate some work while "holding" the stale version.
+		time.Sleep(time.Duration(200+rand.Intn(200)) * time.Millisecond)
+
+		return optimistic.Mutation{
+			Table:         "items",
+			PKColumn:      "id",
+			PKValue:       id,
+			VersionColumn: "version",
+			Version:       version,
+			Sets:          map[string]any{"qty": qty + delta},
+		}, nil
 	}
-	affected, err := res.RowsAffected()
-	if err != nil {
-		return false, err
-	}
-	if affected == 0 {
-		log.Printf("[%s] optimistic attempt #%d: conflict (stale version=%d). Will retry.", now(), attempt, version)
-		return false, nil
-	}
-	log.Printf("[%s] optimistic attempt #%d: success (moved version=%d -> %d, qty change %+d)", now(), attempt, version, version+1, delta)
-	return true, nil
 }
 
 func optimisticDemo(db *sql.DB) {
@@ -120,31 +118,24 @@ func optimisticDemo(db *sql.DB) {
 	// Reset the row to a known state
 	_, _ = db.Exec("UPDATE items SET qty = 100, version = 0 WHERE id = 1")
 
+	opts := optimistic.Options{
+		MaxAttempts: 10,
+		Backoff:     optimistic.ConstantBackoff(10 * time.Millisecond),
+		OnAttempt: func(attempt int, conflict bool, err error) {
+			if conflict {
+				log.Printf("[%s] optimistic attempt #%d: conflict. Will retry.", now(), attempt)
+			}
+		},
+	}
+
 	// Two workers start from the same version and try to update concurrently.
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
-		attempt := 1
-		for {
-			ok, err := optimisticAttempt(db, 1, -7, attempt)
-			must(err)
-			if ok {
-				return
-			}
-			attempt++
-		}
+		must(optimistic.Do(context.Background(), db, opts, optimisticUpdateQty(1, -7)))
 	}()
 
-	// Second worker
-	attempt := 1
-	for {
-		ok, err := optimisticAttempt(db, 1, -3, attempt)
-		must(err)
-		if ok {
-			break
-		}
-		attempt++
-	}
+	must(optimistic.Do(context.Background(), db, opts, optimisticUpdateQty(1, -3)))
 
 	<-done
 	var qty, version int